@@ -0,0 +1,46 @@
+// Package code exposes the 5-character SQLSTATE error codes Postgres uses in
+// ErrorResponse and NoticeResponse messages, as published in the Postgres
+// documentation's "Appendix A. PostgreSQL Error Codes".
+package code
+
+// Connection Exception
+const (
+    ConnectionException                           = "08000"
+    ConnectionDoesNotExist                        = "08003"
+    ConnectionFailure                             = "08006"
+    SQLClientUnableToEstablishSQLConnection       = "08001"
+    SQLServerRejectedEstablishmentOfSQLConnection = "08004"
+)
+
+// Invalid Authorization Specification
+const (
+    InvalidAuthorizationSpecification = "28000"
+    InvalidPassword                   = "28P01"
+)
+
+// Operator Intervention
+const (
+    OperatorIntervention = "57000"
+    QueryCanceled        = "57014"
+    AdminShutdown        = "57P01"
+    CrashShutdown        = "57P02"
+    CannotConnectNow     = "57P03"
+)
+
+// Insufficient Resources
+const (
+    InsufficientResources = "53000"
+    TooManyConnections    = "53300"
+)
+
+// Syntax Error or Access Rule Violation
+const (
+    SyntaxErrorOrAccessRuleViolation = "42000"
+    SyntaxError                      = "42601"
+    InsufficientPrivilege            = "42501"
+    UndefinedTable                   = "42P01"
+    UndefinedColumn                  = "42703"
+)
+
+// Class 00 — Successful Completion
+const SuccessfulCompletion = "00000"
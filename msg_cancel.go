@@ -0,0 +1,88 @@
+package postgressrv
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/binary"
+    "fmt"
+    "sync"
+)
+
+// backendKey identifies a connection the way BackendKeyDataMsg advertised
+// it to the client: by the (pid, secret) pair it must echo back in a
+// cancel request.
+type backendKey struct {
+    pid    int32
+    secret int32
+}
+
+// BackendKeyRegistry ties the (pid, secret) pairs handed out by
+// BackendKeyDataMsg to the cancel function of the connection they belong to,
+// so that a later cancel startup message
+// can actually do something. Embedders call Register once per connection,
+// hand the returned secret to the client with BackendKeyDataMsg, and call
+// Dispatch when they receive a startup message whose IsCancel() is true.
+type BackendKeyRegistry struct {
+    mu    sync.Mutex
+    conns map[backendKey]context.CancelFunc
+}
+
+// NewBackendKeyRegistry creates an empty BackendKeyRegistry.
+func NewBackendKeyRegistry() *BackendKeyRegistry {
+    return &BackendKeyRegistry{conns: make(map[backendKey]context.CancelFunc)}
+}
+
+// Register generates a fresh secret for pid and stores cancel under the
+// resulting (pid, secret) pair, returning that secret (to be handed to the
+// client via BackendKeyDataMsg) and a releaseFn to call once the
+// connection this key belongs to closes.
+func (r *BackendKeyRegistry) Register(pid int32, cancel context.CancelFunc) (secret int32, releaseFn func(), err error) {
+    secret, err = randomSecret()
+    if err != nil {
+        return 0, nil, err
+    }
+
+    key := backendKey{pid: pid, secret: secret}
+
+    r.mu.Lock()
+    r.conns[key] = cancel
+    r.mu.Unlock()
+
+    release := func() {
+        r.mu.Lock()
+        delete(r.conns, key)
+        r.mu.Unlock()
+    }
+    return secret, release, nil
+}
+
+// Dispatch decodes a cancel startup message and invokes the CancelFunc
+// registered for its (pid, secret) pair, if any. As the protocol dictates,
+// the caller should not treat an unknown or already-finished key as an
+// error worth reporting back to the requester: Postgres deliberately gives
+// no indication of whether the cancel had any effect.
+func (r *BackendKeyRegistry) Dispatch(m Msg) error {
+    pid, secret, err := m.CancelKeyData()
+    if err != nil {
+        return err
+    }
+
+    key := backendKey{pid: pid, secret: secret}
+
+    r.mu.Lock()
+    cancel, ok := r.conns[key]
+    r.mu.Unlock()
+
+    if ok {
+        cancel()
+    }
+    return nil
+}
+
+func randomSecret() (int32, error) {
+    var b [4]byte
+    if _, err := rand.Read(b[:]); err != nil {
+        return 0, fmt.Errorf("generating cancel secret: %w", err)
+    }
+    return int32(binary.BigEndian.Uint32(b[:])), nil
+}
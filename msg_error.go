@@ -0,0 +1,137 @@
+package postgressrv
+
+import "encoding/binary"
+
+// ErrorResponseMsg creates a new ErrorResponse message ('E') out of the given
+// field map. Keys are the single-byte field type codes Postgres defines
+// (e.g. 'S' severity, 'C' SQLSTATE, 'M' message); see PgError for a typed,
+// ergonomic way to build that map. The wire format is a sequence of
+// <byte code><NUL-terminated string> pairs terminated by a single NUL byte.
+func ErrorResponseMsg(fields map[byte]string) Msg {
+    return newFieldedMsg('E', fields)
+}
+
+// NoticeResponseMsg creates a new NoticeResponse message ('N'), field-for-
+// field identical to ErrorResponseMsg but advisory rather than fatal to the
+// current query.
+func NoticeResponseMsg(fields map[byte]string) Msg {
+    return newFieldedMsg('N', fields)
+}
+
+func newFieldedMsg(msgType byte, fields map[byte]string) Msg {
+    body := make([]byte, 0, 64)
+    for code, value := range fields {
+        body = append(body, code)
+        body = append(body, value...)
+        body = append(body, 0)
+    }
+    body = append(body, 0)
+
+    msg := make([]byte, 5+len(body))
+    msg[0] = msgType
+    binary.BigEndian.PutUint32(msg[1:5], uint32(4+len(body)))
+    copy(msg[5:], body)
+    return msg
+}
+
+// ParameterStatusMsg creates a new ParameterStatus message ('S') informing
+// the client of the current value of a run-time parameter (e.g. "server_version",
+// "client_encoding"), part of the sequence servers send before ReadyForQuery.
+func ParameterStatusMsg(name, value string) Msg {
+    body := make([]byte, 0, len(name)+len(value)+2)
+    body = append(body, name...)
+    body = append(body, 0)
+    body = append(body, value...)
+    body = append(body, 0)
+
+    msg := make([]byte, 5+len(body))
+    msg[0] = 'S'
+    binary.BigEndian.PutUint32(msg[1:5], uint32(4+len(body)))
+    copy(msg[5:], body)
+    return msg
+}
+
+// PgError is a typed, builder-style way to assemble the field map
+// ErrorResponseMsg and NoticeResponseMsg expect, covering the fields clients
+// in the wild (e.g. the zgrab2 Postgres scanner) actually parse.
+type PgError struct {
+    Severity         string
+    SeverityNonLocalized string
+    SQLState         string
+    Message          string
+    Detail           string
+    Hint             string
+    Position         string
+    File             string
+    Line             string
+    Routine          string
+}
+
+// NewPgError creates a PgError with severity ERROR, ready to be refined with
+// the With* methods and turned into a field map with Fields.
+func NewPgError(sqlstate, message string) *PgError {
+    return &PgError{
+        Severity: "ERROR",
+        SQLState: sqlstate,
+        Message:  message,
+    }
+}
+
+// WithDetail sets the 'D' detail field: a secondary, more specific message.
+func (e *PgError) WithDetail(detail string) *PgError {
+    e.Detail = detail
+    return e
+}
+
+// WithHint sets the 'H' hint field: a suggestion on how to fix the problem.
+func (e *PgError) WithHint(hint string) *PgError {
+    e.Hint = hint
+    return e
+}
+
+// WithPosition sets the 'P' position field: a 1-based index into the
+// original query string where the error was detected.
+func (e *PgError) WithPosition(position string) *PgError {
+    e.Position = position
+    return e
+}
+
+// Fields converts e into the field map ErrorResponseMsg and NoticeResponseMsg
+// expect. 'S', 'C' and 'M' are always included; the other fields are omitted
+// when left unset.
+func (e *PgError) Fields() map[byte]string {
+    fields := map[byte]string{
+        'S': e.Severity,
+        'C': e.SQLState,
+        'M': e.Message,
+    }
+    if e.SeverityNonLocalized != "" {
+        fields['V'] = e.SeverityNonLocalized
+    }
+    if e.Detail != "" {
+        fields['D'] = e.Detail
+    }
+    if e.Hint != "" {
+        fields['H'] = e.Hint
+    }
+    if e.Position != "" {
+        fields['P'] = e.Position
+    }
+    if e.File != "" {
+        fields['F'] = e.File
+    }
+    if e.Line != "" {
+        fields['L'] = e.Line
+    }
+    if e.Routine != "" {
+        fields['R'] = e.Routine
+    }
+    return fields
+}
+
+// Error implements the error interface so a *PgError can be returned and
+// handled like any other Go error, independent of whether it ultimately gets
+// serialized with ErrorResponseMsg.
+func (e *PgError) Error() string {
+    return e.SQLState + ": " + e.Message
+}
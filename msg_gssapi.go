@@ -0,0 +1,22 @@
+package postgressrv
+
+// AuthGSSMsg creates a new AuthenticationGSS message (R, int32=7) telling the
+// client to proceed with a GSSAPI authentication exchange (distinct from the
+// GSSENCRequest/GSSResponseMsg exchange used to negotiate transport
+// encryption; this is the subsequent, optional auth method).
+func AuthGSSMsg() Msg {
+    return newAuthMsg(7, nil)
+}
+
+// AuthGSSContinueMsg creates a new AuthenticationGSSContinue message
+// (R, int32=8) carrying one leg of GSSAPI or SSPI token exchange data.
+func AuthGSSContinueMsg(token []byte) Msg {
+    return newAuthMsg(8, token)
+}
+
+// AuthSSPIMsg creates a new AuthenticationSSPI message (R, int32=9) telling
+// the client to proceed with SSPI authentication, Windows' GSSAPI-compatible
+// equivalent.
+func AuthSSPIMsg() Msg {
+    return newAuthMsg(9, nil)
+}
@@ -0,0 +1,275 @@
+package postgressrv
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "strings"
+
+    "golang.org/x/crypto/pbkdf2"
+)
+
+// scramSHA256Mechanism is the only SASL mechanism currently advertised by the
+// server, matching what modern libpq/pgx clients negotiate by default.
+const scramSHA256Mechanism = "SCRAM-SHA-256"
+
+// scramDefaultIterations is used when generating fresh credentials with
+// NewSCRAMCredential; it has no bearing on verifying a credential that was
+// stored with a different iteration count.
+const scramDefaultIterations = 4096
+
+// AuthSASLMsg creates a new AuthenticationSASL message (R, int32=10) listing
+// the SASL mechanisms the server supports. Only SCRAM-SHA-256 is offered.
+func AuthSASLMsg() Msg {
+    body := []byte(scramSHA256Mechanism + "\x00\x00")
+    return newAuthMsg(10, body)
+}
+
+// AuthSASLContinueMsg creates a new AuthenticationSASLContinue message
+// (R, int32=11) carrying the server-first message during a SCRAM exchange.
+func AuthSASLContinueMsg(data []byte) Msg {
+    return newAuthMsg(11, data)
+}
+
+// AuthSASLFinalMsg creates a new AuthenticationSASLFinal message
+// (R, int32=12) carrying the server's final signature, sent once the client's
+// proof has been verified and immediately followed by AuthOKMsg.
+func AuthSASLFinalMsg(data []byte) Msg {
+    return newAuthMsg(12, data)
+}
+
+// newAuthMsg builds an 'R' message whose payload is a 4-byte auth type
+// followed by body.
+func newAuthMsg(authType int32, body []byte) Msg {
+    msg := make([]byte, 9+len(body))
+    msg[0] = 'R'
+    binary.BigEndian.PutUint32(msg[1:5], uint32(9+len(body)-1))
+    binary.BigEndian.PutUint32(msg[5:9], uint32(authType))
+    copy(msg[9:], body)
+    return msg
+}
+
+// SCRAMCredential holds the per-user secrets a CredentialLookup returns.
+// Passwords are never stored or compared directly; StoredKey and ServerKey
+// are derived once (e.g. at CREATE ROLE time) from SaltedPassword.
+type SCRAMCredential struct {
+    Salt       []byte
+    Iterations int
+    StoredKey  []byte
+    ServerKey  []byte
+}
+
+// CredentialLookup resolves a username to the SCRAM credential the server
+// should authenticate it against. Implementers back this with whatever store
+// holds their users (a config file, a database, an LDAP bind, etc).
+type CredentialLookup func(user string) (SCRAMCredential, error)
+
+// NewSCRAMCredential derives a SCRAMCredential for password, suitable for
+// persisting and later returning from a CredentialLookup.
+func NewSCRAMCredential(password string) (SCRAMCredential, error) {
+    salt := make([]byte, 16)
+    if _, err := rand.Read(salt); err != nil {
+        return SCRAMCredential{}, err
+    }
+
+    saltedPassword := pbkdf2.Key([]byte(password), salt, scramDefaultIterations, sha256.Size, sha256.New)
+    clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+    storedKey := sha256.Sum256(clientKey)
+    serverKey := hmacSHA256(saltedPassword, []byte("Server Key"))
+
+    return SCRAMCredential{
+        Salt:       salt,
+        Iterations: scramDefaultIterations,
+        StoredKey:  storedKey[:],
+        ServerKey:  serverKey,
+    }, nil
+}
+
+// SCRAMExchange drives one SCRAM-SHA-256 handshake from the server side. A
+// new exchange is created per connection attempt and walked forward with
+// ClientFirst and then ClientFinal.
+type SCRAMExchange struct {
+    lookup CredentialLookup
+
+    user            string
+    serverNonce     string
+    clientFirstBare string
+    serverFirst     string
+    gs2Header       string
+    cred            SCRAMCredential
+}
+
+// NewSCRAMExchange creates a SCRAMExchange that authenticates against
+// whatever credential lookup resolves.
+func NewSCRAMExchange(lookup CredentialLookup) *SCRAMExchange {
+    return &SCRAMExchange{lookup: lookup}
+}
+
+// ClientFirst consumes the client-first-message carried in a
+// SASLInitialResponse ('p') message and returns the server-first-message to
+// be wrapped in an AuthSASLContinueMsg.
+//
+// The SASLInitialResponse payload is: mechanism name, NUL, int32 response
+// length, then "<gs2-header>n=<user>,r=<client-nonce>".
+func (x *SCRAMExchange) ClientFirst(m Msg) (string, error) {
+    if m.Type() != 'p' {
+        return "", Errf("Not a SASLInitialResponse: %q", m.Type())
+    }
+
+    buff := m[5:]
+    idx := bytes.IndexByte(buff, 0)
+    if idx == -1 {
+        return "", fmt.Errorf("malformed SASLInitialResponse: missing mechanism terminator")
+    }
+    mechanism := string(buff[:idx])
+    if mechanism != scramSHA256Mechanism {
+        return "", fmt.Errorf("unsupported SASL mechanism: %q", mechanism)
+    }
+    buff = buff[idx+1:]
+
+    if len(buff) < 4 {
+        return "", fmt.Errorf("malformed SASLInitialResponse: missing response length")
+    }
+    buff = buff[4:]
+
+    gs2Header, clientFirstBare, err := stripGS2Header(string(buff))
+    if err != nil {
+        return "", err
+    }
+
+    fields := parseSCRAMFields(clientFirstBare)
+    user, ok := fields["n"]
+    if !ok {
+        return "", fmt.Errorf("client-first-message missing username")
+    }
+    clientNonce, ok := fields["r"]
+    if !ok {
+        return "", fmt.Errorf("client-first-message missing nonce")
+    }
+
+    cred, err := x.lookup(user)
+    if err != nil {
+        return "", err
+    }
+
+    serverNonceSuffix, err := randomNonce()
+    if err != nil {
+        return "", err
+    }
+
+    x.user = user
+    x.serverNonce = clientNonce + serverNonceSuffix
+    x.clientFirstBare = clientFirstBare
+    x.gs2Header = gs2Header
+    x.cred = cred
+
+    x.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d",
+        x.serverNonce, base64.StdEncoding.EncodeToString(cred.Salt), cred.Iterations)
+
+    return x.serverFirst, nil
+}
+
+// ClientFinal consumes the client-final-message carried in a SASLResponse
+// ('p') message, verifies the client's proof, and returns the
+// server-final-message to be wrapped in an AuthSASLFinalMsg. A non-nil error
+// means authentication failed and the connection must be rejected.
+func (x *SCRAMExchange) ClientFinal(m Msg) (string, error) {
+    if m.Type() != 'p' {
+        return "", Errf("Not a SASLResponse: %q", m.Type())
+    }
+
+    clientFinal := string(m[5:])
+    fields := parseSCRAMFields(clientFinal)
+
+    channelBinding, ok := fields["c"]
+    if !ok {
+        return "", fmt.Errorf("client-final-message missing channel binding")
+    }
+    if channelBinding != base64.StdEncoding.EncodeToString([]byte(x.gs2Header)) {
+        return "", fmt.Errorf("channel binding mismatch in client-final-message")
+    }
+    nonce, ok := fields["r"]
+    if !ok {
+        return "", fmt.Errorf("client-final-message missing nonce")
+    }
+    if nonce != x.serverNonce {
+        return "", fmt.Errorf("nonce mismatch in client-final-message")
+    }
+    proofB64, ok := fields["p"]
+    if !ok {
+        return "", fmt.Errorf("client-final-message missing proof")
+    }
+    proof, err := base64.StdEncoding.DecodeString(proofB64)
+    if err != nil {
+        return "", fmt.Errorf("malformed client proof: %w", err)
+    }
+
+    clientFinalWithoutProof := fmt.Sprintf("c=%s,r=%s", channelBinding, nonce)
+    authMessage := x.clientFirstBare + "," + x.serverFirst + "," + clientFinalWithoutProof
+
+    clientSignature := hmacSHA256(x.cred.StoredKey, []byte(authMessage))
+    if len(proof) != len(clientSignature) {
+        return "", fmt.Errorf("SCRAM authentication failed for user %q: malformed client proof length", x.user)
+    }
+    clientKey := xorBytes(proof, clientSignature)
+    storedKey := sha256.Sum256(clientKey)
+
+    if subtle.ConstantTimeCompare(storedKey[:], x.cred.StoredKey) != 1 {
+        return "", fmt.Errorf("SCRAM authentication failed for user %q", x.user)
+    }
+
+    serverSignature := hmacSHA256(x.cred.ServerKey, []byte(authMessage))
+    return "v=" + base64.StdEncoding.EncodeToString(serverSignature), nil
+}
+
+func hmacSHA256(key, data []byte) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write(data)
+    return mac.Sum(nil)
+}
+
+func xorBytes(a, b []byte) []byte {
+    out := make([]byte, len(a))
+    for i := range a {
+        out[i] = a[i] ^ b[i]
+    }
+    return out
+}
+
+func randomNonce() (string, error) {
+    raw := make([]byte, 18)
+    if _, err := rand.Read(raw); err != nil {
+        return "", err
+    }
+    return base64.RawStdEncoding.EncodeToString(raw), nil
+}
+
+// stripGS2Header splits the GS2 header (e.g. "n,,") from a client-first
+// message, returning the header itself and the remaining bare message used
+// in the AuthMessage signature.
+func stripGS2Header(message string) (header, bare string, err error) {
+    parts := strings.SplitN(message, ",", 3)
+    if len(parts) < 3 {
+        return "", "", fmt.Errorf("malformed client-first-message: missing GS2 header")
+    }
+    return parts[0] + "," + parts[1] + ",", parts[2], nil
+}
+
+// parseSCRAMFields splits a comma-separated "k=v" attribute list as used
+// throughout SCRAM messages.
+func parseSCRAMFields(s string) map[string]string {
+    fields := make(map[string]string)
+    for _, part := range strings.Split(s, ",") {
+        kv := strings.SplitN(part, "=", 2)
+        if len(kv) != 2 {
+            continue
+        }
+        fields[kv[0]] = kv[1]
+    }
+    return fields
+}
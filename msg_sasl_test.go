@@ -0,0 +1,136 @@
+package postgressrv
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "testing"
+
+    "golang.org/x/crypto/pbkdf2"
+)
+
+// newClientFirstMsg builds the SASLInitialResponse ClientFirst expects:
+// mechanism name, NUL, int32 response length, then the response bytes.
+func newClientFirstMsg(mechanism, response string) Msg {
+    body := []byte(mechanism + "\x00")
+    lenField := make([]byte, 4)
+    binary.BigEndian.PutUint32(lenField, uint32(len(response)))
+    body = append(body, lenField...)
+    body = append(body, response...)
+
+    msg := make([]byte, 5+len(body))
+    msg[0] = 'p'
+    binary.BigEndian.PutUint32(msg[1:5], uint32(4+len(body)))
+    copy(msg[5:], body)
+    return msg
+}
+
+// newSASLResponseMsg builds a SASLResponse ('p') message carrying data
+// verbatim, as ClientFinal expects to read it.
+func newSASLResponseMsg(data []byte) Msg {
+    msg := make([]byte, 5+len(data))
+    msg[0] = 'p'
+    binary.BigEndian.PutUint32(msg[1:5], uint32(4+len(data)))
+    copy(msg[5:], data)
+    return msg
+}
+
+// computeClientProof walks through the client side of the math ClientFinal
+// verifies, so tests can produce a valid proof for a known password.
+func computeClientProof(cred SCRAMCredential, password, authMessage string) []byte {
+    saltedPassword := pbkdf2.Key([]byte(password), cred.Salt, cred.Iterations, sha256.Size, sha256.New)
+    clientKey := hmacSHA256(saltedPassword, []byte("Client Key"))
+    clientSignature := hmacSHA256(cred.StoredKey, []byte(authMessage))
+    return xorBytes(clientKey, clientSignature)
+}
+
+func TestSCRAMExchangeHappyPath(t *testing.T) {
+    const password = "s3kret"
+    cred, err := NewSCRAMCredential(password)
+    if err != nil {
+        t.Fatalf("NewSCRAMCredential: %v", err)
+    }
+
+    lookup := func(user string) (SCRAMCredential, error) {
+        if user != "alice" {
+            return SCRAMCredential{}, fmt.Errorf("unknown user %q", user)
+        }
+        return cred, nil
+    }
+
+    x := NewSCRAMExchange(lookup)
+
+    clientFirstBare := "n=alice,r=clientnonce"
+    serverFirst, err := x.ClientFirst(newClientFirstMsg(scramSHA256Mechanism, "n,,"+clientFirstBare))
+    if err != nil {
+        t.Fatalf("ClientFirst: %v", err)
+    }
+
+    serverNonce := parseSCRAMFields(serverFirst)["r"]
+    channelBinding := base64.StdEncoding.EncodeToString([]byte("n,,"))
+    clientFinalWithoutProof := fmt.Sprintf("c=%s,r=%s", channelBinding, serverNonce)
+    authMessage := clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof
+    proof := computeClientProof(cred, password, authMessage)
+
+    clientFinal := clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)
+    serverFinal, err := x.ClientFinal(newSASLResponseMsg([]byte(clientFinal)))
+    if err != nil {
+        t.Fatalf("ClientFinal: %v", err)
+    }
+    if len(serverFinal) < 2 || serverFinal[:2] != "v=" {
+        t.Fatalf("unexpected server-final-message: %q", serverFinal)
+    }
+}
+
+func TestSCRAMExchangeRejectsOversizedProof(t *testing.T) {
+    cred, err := NewSCRAMCredential("s3kret")
+    if err != nil {
+        t.Fatalf("NewSCRAMCredential: %v", err)
+    }
+
+    x := NewSCRAMExchange(func(user string) (SCRAMCredential, error) { return cred, nil })
+
+    clientFirstBare := "n=alice,r=clientnonce"
+    serverFirst, err := x.ClientFirst(newClientFirstMsg(scramSHA256Mechanism, "n,,"+clientFirstBare))
+    if err != nil {
+        t.Fatalf("ClientFirst: %v", err)
+    }
+
+    serverNonce := parseSCRAMFields(serverFirst)["r"]
+    channelBinding := base64.StdEncoding.EncodeToString([]byte("n,,"))
+    clientFinalWithoutProof := fmt.Sprintf("c=%s,r=%s", channelBinding, serverNonce)
+
+    oversizedProof := make([]byte, 2*sha256.Size)
+    clientFinal := clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(oversizedProof)
+
+    if _, err := x.ClientFinal(newSASLResponseMsg([]byte(clientFinal))); err == nil {
+        t.Fatal("expected an error for an oversized client proof, got nil")
+    }
+}
+
+func TestSCRAMExchangeRejectsChannelBindingMismatch(t *testing.T) {
+    cred, err := NewSCRAMCredential("s3kret")
+    if err != nil {
+        t.Fatalf("NewSCRAMCredential: %v", err)
+    }
+
+    x := NewSCRAMExchange(func(user string) (SCRAMCredential, error) { return cred, nil })
+
+    clientFirstBare := "n=alice,r=clientnonce"
+    serverFirst, err := x.ClientFirst(newClientFirstMsg(scramSHA256Mechanism, "n,,"+clientFirstBare))
+    if err != nil {
+        t.Fatalf("ClientFirst: %v", err)
+    }
+
+    serverNonce := parseSCRAMFields(serverFirst)["r"]
+    tamperedBinding := base64.StdEncoding.EncodeToString([]byte("y,,"))
+    clientFinalWithoutProof := fmt.Sprintf("c=%s,r=%s", tamperedBinding, serverNonce)
+    authMessage := clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof
+    proof := computeClientProof(cred, "s3kret", authMessage)
+
+    clientFinal := clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)
+    if _, err := x.ClientFinal(newSASLResponseMsg([]byte(clientFinal))); err == nil {
+        t.Fatal("expected an error for a tampered channel-binding attribute, got nil")
+    }
+}
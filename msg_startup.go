@@ -4,6 +4,7 @@ import (
     "fmt"
     "bytes"
     "encoding/binary"
+    "strings"
 )
 
 // Version returns the protocol version supported by the client. The version is
@@ -60,6 +61,91 @@ func (m Msg) StartupArgs() (map[string]string, error) {
     return args, nil
 }
 
+// protocolMinorVersion is the highest minor version of protocol 3.x this
+// server understands. Clients advertising a newer minor (or any unrecognized
+// _pq_.* parameter) are told to fall back via NegotiateProtocolVersionMsg.
+const protocolMinorVersion = 0
+
+// ProtocolOption registers a _pq_.* parameter name that this server
+// recognizes, so that NeedsProtocolNegotiation does not count it as
+// unrecognized. StartupProtocolOptions returns every _pq_.* parameter,
+// registered or not.
+func ProtocolOption(name string) {
+    recognizedProtocolOptions[name] = true
+}
+
+var recognizedProtocolOptions = map[string]bool{}
+
+// StartupProtocolOptions returns the subset of StartupArgs whose keys begin
+// with "_pq_.", the namespace libpq 14+ uses for protocol extensions
+// negotiated outside of GUC parameters (e.g. "_pq_.libpq_prototype_async").
+func (m Msg) StartupProtocolOptions() (map[string]string, error) {
+    args, err := m.StartupArgs()
+    if err != nil {
+        return nil, err
+    }
+
+    opts := make(map[string]string)
+    for k, v := range args {
+        if strings.HasPrefix(k, "_pq_.") {
+            opts[k] = v
+        }
+    }
+    return opts, nil
+}
+
+// NeedsProtocolNegotiation determines whether the server must respond with a
+// NegotiateProtocolVersionMsg before proceeding to authentication: the
+// client advertised a minor version newer than this server supports, or sent
+// any _pq_.* parameter this server doesn't recognize.
+func (m Msg) NeedsProtocolNegotiation() (bool, error) {
+    version, err := m.StartupVersion()
+    if err != nil {
+        return false, err
+    }
+
+    major, minor := 0, 0
+    if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+        return false, err
+    }
+    if major == 3 && minor > protocolMinorVersion {
+        return true, nil
+    }
+
+    opts, err := m.StartupProtocolOptions()
+    if err != nil {
+        return false, err
+    }
+    for name := range opts {
+        if !recognizedProtocolOptions[name] {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// NegotiateProtocolVersionMsg creates a new NegotiateProtocolVersion message
+// ('v') informing the client of the highest protocol minor version this
+// server supports and which of its requested protocol options ("_pq_.*"
+// parameters) were not recognized. It must be sent before authentication
+// whenever (m Msg).NeedsProtocolNegotiation() is true.
+func NegotiateProtocolVersionMsg(supportedMinor int32, unsupportedOpts []string) Msg {
+    buff := new(bytes.Buffer)
+    binary.Write(buff, binary.BigEndian, supportedMinor)
+    binary.Write(buff, binary.BigEndian, int32(len(unsupportedOpts)))
+    for _, opt := range unsupportedOpts {
+        buff.WriteString(opt)
+        buff.WriteByte(0)
+    }
+
+    body := buff.Bytes()
+    msg := make([]byte, 5+len(body))
+    msg[0] = 'v'
+    binary.BigEndian.PutUint32(msg[1:5], uint32(4+len(body)))
+    copy(msg[5:], body)
+    return msg
+}
+
 // IsTLSRequest determines if this startup message is actually a request to open
 // a TLS connection, in which case the version number is a special, predefined
 // value of "1234.5679"
@@ -68,6 +154,16 @@ func (m Msg) IsTLSRequest() bool {
     return v == "1234.5679"
 }
 
+// IsGSSENCRequest determines if this startup message is actually a request to
+// negotiate GSSAPI encryption, in which case the version number is a special,
+// predefined value of "1234.5680". libpq sends this before SSLRequest as of
+// version 12, and falls back from GSS to SSL to plaintext in that order when
+// a server responds that it doesn't support one.
+func (m Msg) IsGSSENCRequest() bool {
+    v, _ := m.StartupVersion()
+    return v == "1234.5680"
+}
+
 // IsInternal determines if this startup message is actually a peer node
 // connecting for internal communication. We're keeping with Postgre's current
 // approach of using a special version number. NOTE that internal connections
@@ -94,15 +190,25 @@ func TLSResponseMsg(supported bool) Msg {
     return Msg([]byte{b})
 }
 
+// GSSResponseMsg creates a new single byte message indicating if the server
+// supports GSSAPI encryption or not, symmetric to TLSResponseMsg. If it does,
+// the client must immediately proceed to initiate the GSSAPI handshake; if it
+// doesn't, the server responds 'N' and continues reading a normal startup
+// message (the client may then fall back to SSLRequest).
+func GSSResponseMsg(supported bool) Msg {
+    b := map[bool]byte{true: 'G', false: 'N'}[supported]
+    return Msg([]byte{b})
+}
+
 // NewAuthOK creates a new message indicating that the authentication was
 // successful
 func AuthOKMsg() Msg {
     return []byte{'R', 0, 0, 0, 8, 0, 0, 0, 0}
 }
 
-// KeyDataMsg creates a new message providing the client with a process ID and
-// secret key that it can later use to cancel running queries
-func KeyDataMsg(pid int32, secret int32) Msg {
+// BackendKeyDataMsg creates a new message providing the client with a
+// process ID and secret key that it can later use to cancel running queries
+func BackendKeyDataMsg(pid int32, secret int32) Msg {
     msg := []byte{'K', 0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0}
     binary.BigEndian.PutUint32(msg[5:9], uint32(pid))
     binary.BigEndian.PutUint32(msg[9:13], uint32(secret))
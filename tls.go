@@ -0,0 +1,121 @@
+package postgressrv
+
+import (
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/http"
+
+    "golang.org/x/crypto/acme/autocert"
+)
+
+// AutoTLSConfig configures AutoTLS. Cache defaults to autocert's in-memory
+// cache when nil; set it to autocert.DirCache(dir) to persist issued
+// certificates across restarts.
+type AutoTLSConfig struct {
+    // HostPolicy restricts which hostnames autocert will request certificates
+    // for. Use autocert.HostWhitelist in production; leaving it nil accepts
+    // any hostname, which lets anyone who can point DNS at this server mint
+    // certificates through it.
+    HostPolicy autocert.HostPolicy
+
+    // Cache stores issued certificates between restarts. Defaults to an
+    // in-memory cache, which re-issues on every restart.
+    Cache autocert.Cache
+
+    // HTTPChallengeAddr, when non-empty, makes NewAutoTLSHandler start a
+    // side-goroutine HTTP-01 challenge listener on this address (typically
+    // ":80"). Handle's TLS config does not negotiate the acme-tls/1 ALPN
+    // protocol, so TLS-ALPN-01 challenges are not answered through it.
+    HTTPChallengeAddr string
+
+    // RequireTLS makes the server refuse any connection whose startup
+    // message isn't an SSLRequest, matching libpq's sslmode=require.
+    RequireTLS bool
+}
+
+// AutoTLSHandler wires golang.org/x/crypto/acme/autocert into accepted
+// connections so that an SSLRequest startup message is answered with a real,
+// CA-signed certificate instead of requiring the caller to provide one. It
+// holds a single autocert.Manager shared across every connection it handles,
+// which is load-bearing: the Manager is what deduplicates concurrent ACME
+// orders for the same hostname and, when an HTTP-01 listener is running,
+// answers challenges for certificates it itself requested. Construct one
+// AutoTLSHandler per process with NewAutoTLSHandler and call Handle once per
+// accepted connection.
+type AutoTLSHandler struct {
+    cfg     AutoTLSConfig
+    manager *autocert.Manager
+}
+
+// NewAutoTLSHandler creates an AutoTLSHandler backed by a single
+// autocert.Manager. If cfg.HTTPChallengeAddr is set, it also starts the
+// HTTP-01 challenge listener in its own goroutine for the lifetime of the
+// process.
+func NewAutoTLSHandler(cfg AutoTLSConfig) *AutoTLSHandler {
+    manager := &autocert.Manager{
+        Prompt:     autocert.AcceptTOS,
+        HostPolicy: cfg.HostPolicy,
+        Cache:      cfg.Cache,
+    }
+
+    if cfg.HTTPChallengeAddr != "" {
+        go StartHTTPChallengeServer(cfg.HTTPChallengeAddr, manager)
+    }
+
+    return &AutoTLSHandler{cfg: cfg, manager: manager}
+}
+
+// Handle performs the AutoTLS handshake for a single accepted connection. It
+// returns the real StartupMessage read from the now-encrypted stream, along
+// with the encrypted net.Conn that should replace conn for the remainder of
+// the session. When the client does not request TLS and RequireTLS is unset,
+// it returns conn itself and the plaintext startup message it read.
+func (h *AutoTLSHandler) Handle(conn net.Conn) (net.Conn, Msg, error) {
+    startup, err := ReadStartupMsg(conn)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    if !startup.IsTLSRequest() {
+        if h.cfg.RequireTLS {
+            conn.Write(TLSResponseMsg(false))
+            conn.Close()
+            return nil, nil, fmt.Errorf("client did not request TLS and RequireTLS is set")
+        }
+        return conn, startup, nil
+    }
+
+    if _, err := conn.Write(TLSResponseMsg(true)); err != nil {
+        return nil, nil, err
+    }
+
+    tlsConn := tls.Server(conn, &tls.Config{GetCertificate: h.manager.GetCertificate})
+    if err := tlsConn.Handshake(); err != nil {
+        return nil, nil, fmt.Errorf("TLS handshake failed: %w", err)
+    }
+
+    real, err := ReadStartupMsg(tlsConn)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    return tlsConn, real, nil
+}
+
+// StartHTTPChallengeServer runs manager's HTTP-01 challenge handler on addr
+// and returns only when http.ListenAndServe fails, with its error. It's meant
+// to be run in its own goroutine
+// for the lifetime of the server, e.g.:
+//
+//  go StartHTTPChallengeServer(":80", manager)
+func StartHTTPChallengeServer(addr string, manager *autocert.Manager) error {
+    return http.ListenAndServe(addr, manager.HTTPHandler(nil))
+}
+
+// ReadStartupMsg reads a single (potentially pre-TLS) startup message off
+// conn. It is exposed so callers that implement their own TLS negotiation
+// can re-read the real StartupMessage once the connection is upgraded.
+func ReadStartupMsg(conn net.Conn) (Msg, error) {
+    return ReadMsg(conn)
+}